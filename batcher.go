@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// BuildRequest is a coalesced set of file-change events, ready to be
+// handed to the builder as a single rebuild.
+type BuildRequest struct {
+	ChangedFiles []string
+	Reason       string
+}
+
+// batcher reads raw changed paths from in and coalesces them into a
+// single BuildRequest on out, deduping repeated paths. Every new event
+// restarts the debounce timer, so a batch is sent once debounce has
+// passed without a further event. out is expected
+// to be buffered to maxQueue; once full, new requests are dropped with
+// a log message instead of blocking.
+func batcher(ctx context.Context, in <-chan string, out chan<- BuildRequest, debounce time.Duration, maxQueue int) {
+	seen := map[string]struct{}{}
+	var timer <-chan time.Time
+
+	flush := func() {
+		if len(seen) == 0 {
+			return
+		}
+
+		files := make([]string, 0, len(seen))
+		for f := range seen {
+			files = append(files, f)
+		}
+		seen = map[string]struct{}{}
+		timer = nil
+
+		select {
+		case out <- BuildRequest{ChangedFiles: files, Reason: "file change"}:
+		default:
+			log.Println(failColor("Build queue full (max-queue=%d), dropping batch of %d changed files.", maxQueue, len(files)))
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case path, ok := <-in:
+			if !ok {
+				return
+			}
+			seen[path] = struct{}{}
+			timer = time.After(debounce)
+
+		case <-timer:
+			flush()
+		}
+	}
+}
+
+// changedFilesEnv renders a BuildRequest's ChangedFiles as the
+// COMPILEDAEMON_CHANGED environment variable passed to the build
+// command and its hooks.
+func changedFilesEnv(changedFiles []string) []string {
+	return []string{"COMPILEDAEMON_CHANGED=" + strings.Join(changedFiles, " ")}
+}
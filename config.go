@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Watcher describes which files within a Project's Path should be
+// monitored for changes.
+type Watcher struct {
+	// Exts is the list of file extensions (without the leading dot)
+	// that should trigger a rebuild, e.g. "go", "tmpl".
+	Exts []string `yaml:"exts"`
+	// Paths is an optional list of sub-directories to watch instead of
+	// the whole Project.Path tree.
+	Paths []string `yaml:"paths"`
+	// Scripts lists extra files (not matched by Exts) that should also
+	// be watched, such as Makefiles or config templates.
+	Scripts []string `yaml:"scripts"`
+	// Ignore is a list of glob patterns matched against file and
+	// directory basenames; matches are never watched.
+	Ignore []string `yaml:"ignore"`
+}
+
+// Tools holds the commands run for a Project.
+type Tools struct {
+	Build string `yaml:"build"`
+	Run   string `yaml:"run"`
+}
+
+// Project is a single independently watched-and-built unit within a
+// multi-project configuration file.
+type Project struct {
+	Name    string   `yaml:"name"`
+	Path    string   `yaml:"path"`
+	Env     []string `yaml:"env"`
+	Args    []string `yaml:"args"`
+	Watcher Watcher  `yaml:"watcher"`
+	Tools   Tools    `yaml:"tools"`
+	Hooks   Hooks    `yaml:"hooks"`
+}
+
+// Config is the top-level document parsed from -config=XXX.yaml. It
+// describes every Project CompileDaemon should watch and build
+// concurrently.
+type Config struct {
+	Projects []Project `yaml:"projects"`
+}
+
+// LoadConfig reads and parses the YAML config file at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read config file: %s", err)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("can't parse config file: %s", err)
+	}
+
+	if len(cfg.Projects) == 0 {
+		return nil, fmt.Errorf("config file %s defines no projects", path)
+	}
+
+	for i := range cfg.Projects {
+		if cfg.Projects[i].Path == "" {
+			cfg.Projects[i].Path = "."
+		}
+		if cfg.Projects[i].Name == "" {
+			cfg.Projects[i].Name = cfg.Projects[i].Path
+		}
+	}
+
+	return &cfg, nil
+}
@@ -49,12 +49,36 @@ There are command line options.
 	ACTIONS
 	-build=CCC        – Execute CCC to rebuild when a file changes
 	-command=CCC      – Run command CCC after a successful build, stops previous command first
+	-before-build=CCC – Run hook command CCC before the build command, can be repeated
+	-after-build=CCC  – Run hook command CCC after a successful build, can be repeated
+	-on-failure=CCC   – Run hook command CCC after a failed build, can be repeated
+	-debounce=XXX     – Coalesce file changes seen within this window into one build (default 900ms)
+	-max-queue=N      – Maximum number of pending build requests to queue before dropping new ones
+	-shell            – Run -build/-command strings through the platform shell ("/bin/sh -c"
+	                    or "cmd /c" on Windows) instead of tokenizing them, so redirection, pipes and
+	                    shell variables work as written. Hooks given on the command line always run
+	                    through the platform shell, with or without -shell
+
+The build command and every hook see the files that triggered the
+rebuild in the $COMPILEDAEMON_CHANGED environment variable, space
+separated.
+
+	MULTI-PROJECT
+	-config=XXX.yaml  – Watch and build multiple independent projects concurrently,
+	                    each with its own directory, watcher and build/run commands,
+	                    instead of the file selection, build/run and hook flags above.
+	                    -debounce, -max-queue, -shell, -color and -graceful-kill still apply.
+
+CompileDaemon shuts down cleanly on SIGINT/SIGTERM, killing the running
+child process and draining the file watcher before exiting. In -config
+mode, SIGHUP reloads the config file instead of exiting.
 
 */
 package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/fatih/color"
@@ -66,6 +90,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -104,11 +129,18 @@ var (
 	flag_build        = flag.String("build", "go build", "Command to rebuild after changes")
 	flag_color        = flag.Bool("color", true, "Colorize output for CompileDaemon status messages")
 	flag_gracefulkill = flag.Bool("graceful-kill", true, "Gracefully attempt to kill the child process by sending a SIGTERM first")
+	flag_config       = flag.String("config", "", "Config file declaring multiple projects to watch and build concurrently, e.g. -config=compiledaemon.yaml")
+	flag_debounce     = flag.Duration("debounce", WorkDelay*time.Millisecond, "Coalesce file changes seen within this window into a single build")
+	flag_maxQueue     = flag.Int("max-queue", 16, "Maximum number of pending build requests to queue before dropping new ones")
+	flag_shell        = flag.Bool("shell", false, "Run -build/-command strings through the platform shell instead of tokenizing them")
 
 	// initialized in main() due to custom type.
 	flag_excludedDirs  globList
 	flag_excludedFiles globList
 	flag_includedFiles globList
+	flag_beforeBuild   stringList
+	flag_afterBuild    stringList
+	flag_onFailure     stringList
 )
 
 type colorFunc func(string, ...interface{}) string
@@ -129,26 +161,44 @@ func failColor(format string, args ...interface{}) string {
 	}
 }
 
-// Run `go build` and print the output if something's gone wrong.
-func build() bool {
+// Run `go build` and print the output if something's gone wrong. Runs
+// hooks.BeforeBuild first, aborting the build if any of them fail, then
+// hooks.AfterBuild or hooks.OnFailure depending on the build's outcome.
+// changedFiles is exposed to the build command and every hook as the
+// COMPILEDAEMON_CHANGED environment variable. dir is the directory the
+// build command runs in.
+func build(buildCmd string, hooks Hooks, changedFiles []string, dir string) bool {
+	env := changedFilesEnv(changedFiles)
+
+	if err := runHooks(hooks.BeforeBuild, env); err != nil {
+		log.Println(failColor("Before-build hook failed, skipping build:\n"), failColor(err.Error()))
+		return false
+	}
+
 	log.Println(okColor("Running build command!"))
 
-	args := strings.Split(*flag_build, " ")
-	if len(args) == 0 {
-		// If the user has specified and empty then we are done.
+	if strings.TrimSpace(buildCmd) == "" {
+		// If the user has specified an empty build command then we are done.
 		return true
 	}
 
-	cmd := exec.Command(args[0], args[1:]...)
+	cmd, err := makeCommand(buildCmd)
+	if err != nil {
+		log.Println(failColor("Could not parse build command:\n"), failColor(err.Error()))
+		return false
+	}
 
-	cmd.Dir = "."
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
 
 	output, err := cmd.CombinedOutput()
 
 	if err == nil {
 		log.Println(okColor("Build ok.\n"), okColor(string(output)))
+		runHooks(hooks.AfterBuild, env)
 	} else {
 		log.Println(failColor("Error while building:\n"), failColor(string(output)))
+		runHooks(hooks.OnFailure, env)
 	}
 
 	return err == nil
@@ -158,23 +208,20 @@ func matchesPattern(pattern *regexp.Regexp, file string) bool {
 	return pattern.MatchString(file)
 }
 
-// Accept build jobs and start building when there are no jobs rushing in.
-// The inrush protection is WorkDelay milliseconds long, in this period
-// every incoming job will reset the timer.
-func builder(jobs <-chan string, buildDone chan<- struct{}) {
-	createThreshold := func() <-chan time.Time {
-		return time.After(time.Duration(WorkDelay * time.Millisecond))
-	}
-
-	threshold := createThreshold()
-
+// Accept already-debounced BuildRequests (see batcher) and build each
+// one in turn, in dir.
+func builder(ctx context.Context, reqs <-chan BuildRequest, buildDone chan<- struct{}, buildCmd string, hooks Hooks, dir string) {
 	for {
 		select {
-		case <-jobs:
-			threshold = createThreshold()
-		case <-threshold:
-			if build() {
-				buildDone <- struct{}{}
+		case <-ctx.Done():
+			return
+		case req := <-reqs:
+			if build(buildCmd, hooks, req.ChangedFiles, dir) {
+				select {
+				case buildDone <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
 			}
 		}
 	}
@@ -205,11 +252,9 @@ func logger(pipeChan <-chan io.ReadCloser) {
 	}
 }
 
-// Start the supplied command and return stdout and stderr pipes for logging.
-func startCommand(command string) (cmd *exec.Cmd, stdout io.ReadCloser, stderr io.ReadCloser, err error) {
-	args := strings.Split(command, " ")
-	cmd = exec.Command(args[0], args[1:]...)
-
+// startProcess wires up stdout/stderr pipes, starts cmd and registers
+// it with the reaper. Shared by startCommand and startProjectCommand.
+func startProcess(cmd *exec.Cmd) (stdout io.ReadCloser, stderr io.ReadCloser, err error) {
 	if stdout, err = cmd.StdoutPipe(); err != nil {
 		err = fmt.Errorf("can't get stdout pipe for command: %s", err)
 		return
@@ -225,59 +270,100 @@ func startCommand(command string) (cmd *exec.Cmd, stdout io.ReadCloser, stderr i
 		return
 	}
 
+	reaperRegister(cmd.Process.Pid)
+
 	return
 }
 
-// Run the command in the given string and restart it after
-// a message was received on the buildDone channel.
-func runner(command string, buildDone <-chan struct{}) {
-	var currentProcess *os.Process
+// Start the supplied command string in dir and return stdout and
+// stderr pipes for logging.
+func startCommand(command string, dir string) (cmd *exec.Cmd, stdout io.ReadCloser, stderr io.ReadCloser, err error) {
+	if cmd, err = makeCommand(command); err != nil {
+		err = fmt.Errorf("can't parse command: %s", err)
+		return
+	}
+
+	cmd.Dir = dir
+
+	stdout, stderr, err = startProcess(cmd)
+	return
+}
+
+// Run the command started by start and restart it after a message was
+// received on the buildDone channel. state tracks the in-flight
+// process so shutdown() can reach it; it must not be shared with any
+// other runner() goroutine.
+func runner(ctx context.Context, start func() (*exec.Cmd, io.ReadCloser, io.ReadCloser, error), buildDone <-chan struct{}, state *processState) {
 	pipeChan := make(chan io.ReadCloser)
 
 	go logger(pipeChan)
 
 	for {
-		<-buildDone
-
-		if currentProcess != nil {
-			killProcess(currentProcess)
-		}
+		select {
+		case <-ctx.Done():
+			state.killRunningProcess()
+			return
+		case <-buildDone:
+			state.killRunningProcess()
 
-		log.Println(okColor("Restarting the given command."))
-		cmd, stdoutPipe, stderrPipe, err := startCommand(command)
+			log.Println(okColor("Restarting the given command."))
+			cmd, stdoutPipe, stderrPipe, err := start()
 
-		if err != nil {
-			log.Fatal(failColor("Could not start command:", err))
-		}
+			if err != nil {
+				log.Fatal(failColor("Could not start command:", err))
+			}
 
-		pipeChan <- stdoutPipe
-		pipeChan <- stderrPipe
+			pipeChan <- stdoutPipe
+			pipeChan <- stderrPipe
 
-		currentProcess = cmd.Process
+			state.setRunningProcess(cmd.Process)
+		}
 	}
 }
 
-func killProcess(process *os.Process) {
+// killProcess stops process and returns the status it exited with, or -1
+// if that status couldn't be determined.
+func killProcess(process *os.Process) int {
 	if *flag_gracefulkill {
-		killProcessGracefully(process)
-	} else {
-		killProcessHard(process)
+		return killProcessGracefully(process)
 	}
+	return killProcessHard(process)
 }
 
-func killProcessHard(process *os.Process) {
+// killProcessHard sends SIGKILL to process and returns the status the
+// reaper reports it exited with. If the process already exited on its
+// own between builds (e.g. it crashed) and was already reaped,
+// reaperTryWait reports that directly and process.Kill() is skipped -
+// there's nothing left to kill, and treating that as fatal would take
+// down the whole daemon over an expected outcome.
+func killProcessHard(process *os.Process) int {
+	if exit, ok := reaperTryWait(process.Pid); ok {
+		log.Println(okColor("Child process had already exited with status %d.", exit.Status))
+		return exit.Status
+	}
+
 	log.Println(okColor("Hard stopping the current process.."))
 
 	if err := process.Kill(); err != nil {
-		log.Fatal(failColor("Could not kill child process. Aborting due to danger of infinite forks."))
+		log.Println(failColor("Could not kill child process (it may have already exited): %s", err.Error()))
 	}
 
-	if _, err := process.Wait(); err != nil {
-		log.Fatal(failColor("Could not wait for child process. Aborting due to danger of infinite forks."))
+	if exit, err := reaperWait(process.Pid, 3*time.Second); err != nil {
+		log.Println(failColor("Could not wait for child process: %s", err.Error()))
+		return -1
+	} else {
+		log.Println(okColor("Child process exited with status %d.", exit.Status))
+		return exit.Status
 	}
 }
 
-func killProcessGracefully(process *os.Process) {
+func killProcessGracefully(process *os.Process) int {
+	if exit, ok := reaperTryWait(process.Pid); ok {
+		log.Println(okColor("Child process had already exited with status %d.", exit.Status))
+		return exit.Status
+	}
+
+	status := -1
 	done := make(chan error, 1)
 	go func() {
 		log.Println(okColor("Gracefully stopping the current process.."))
@@ -285,25 +371,35 @@ func killProcessGracefully(process *os.Process) {
 			done <- err
 			return
 		}
-		_, err := process.Wait()
+		exit, err := reaperWait(process.Pid, 3*time.Second)
+		if err == nil {
+			log.Println(okColor("Child process exited with status %d.", exit.Status))
+			status = exit.Status
+		}
 		done <- err
 	}()
 
 	select {
 	case <-time.After(3 * time.Second):
 		log.Println(failColor("Could not gracefully stop the current process, proceeding to hard stop."))
-		killProcessHard(process)
+		status = killProcessHard(process)
 		<-done
 	case err := <-done:
 		if err != nil {
 			log.Fatal(failColor("Could not kill child process. Aborting due to danger of infinite forks."))
 		}
 	}
+
+	return status
 }
 
-func flusher(buildDone <-chan struct{}) {
+func flusher(ctx context.Context, buildDone <-chan struct{}) {
 	for {
-		<-buildDone
+		select {
+		case <-ctx.Done():
+			return
+		case <-buildDone:
+		}
 	}
 }
 
@@ -311,10 +407,41 @@ func main() {
 	flag.Var(&flag_excludedDirs, "exclude-dir", " Don't watch directories matching this name")
 	flag.Var(&flag_excludedFiles, "exclude", " Don't watch files matching this name")
 	flag.Var(&flag_includedFiles, "include", " Watch files matching this name")
+	flag.Var(&flag_beforeBuild, "before-build", " Hook command run before the build command")
+	flag.Var(&flag_afterBuild, "after-build", " Hook command run after a successful build")
+	flag.Var(&flag_onFailure, "on-failure", " Hook command run after a failed build")
 
 	flag.Parse()
 	log.SetFlags(0)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if *flag_config != "" {
+		cfg, err := LoadConfig(*flag_config)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var gen projectGeneration
+		gen.start(ctx, cfg.Projects, &wg)
+
+		installSignalHandler(cancel, func() {
+			log.Println(okColor("Reloading config file."))
+			if cfg, err := LoadConfig(*flag_config); err != nil {
+				log.Println(failColor("Could not reload config: %s", err.Error()))
+			} else {
+				gen.start(ctx, cfg.Projects, &wg)
+			}
+		})
+
+		<-ctx.Done()
+		gen.stop()
+		shutdown(&wg, func() {}, gen.snapshot()...)
+		return
+	}
+
 	if *flag_directory == "" {
 		fmt.Fprintf(os.Stderr, "-directory=... is required.\n")
 		os.Exit(1)
@@ -330,8 +457,6 @@ func main() {
 		log.Fatal(err)
 	}
 
-	defer watcher.Close()
-
 	if *flag_recursive == true {
 		err = filepath.Walk(*flag_directory, func(path string, info os.FileInfo, err error) error {
 			if err == nil && info.IsDir() {
@@ -355,26 +480,64 @@ func main() {
 	}
 
 	pattern := regexp.MustCompile(*flag_pattern)
-	jobs := make(chan string)
+	raw := make(chan string, 4**flag_maxQueue)
+	buildReqs := make(chan BuildRequest, *flag_maxQueue)
 	buildDone := make(chan struct{})
 
-	go builder(jobs, buildDone)
-
-	if *flag_command != "" {
-		go runner(*flag_command, buildDone)
-	} else {
-		go flusher(buildDone)
+	cliBuildHooks := Hooks{
+		BeforeBuild: cliHooks(flag_beforeBuild),
+		AfterBuild:  cliHooks(flag_afterBuild),
+		OnFailure:   cliHooks(flag_onFailure),
 	}
 
+	installSignalHandler(cancel, func() {
+		log.Println(okColor("Reloading is only supported in -config mode; ignoring SIGHUP."))
+	})
+
+	var wg sync.WaitGroup
+	runState := &processState{}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		batcher(ctx, raw, buildReqs, *flag_debounce, *flag_maxQueue)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		builder(ctx, buildReqs, buildDone, *flag_build, cliBuildHooks, ".")
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if *flag_command != "" {
+			runner(ctx, func() (*exec.Cmd, io.ReadCloser, io.ReadCloser, error) {
+				return startCommand(*flag_command, ".")
+			}, buildDone, runState)
+		} else {
+			flusher(ctx, buildDone)
+		}
+	}()
+
 	for {
 		select {
+		case <-ctx.Done():
+			shutdown(&wg, func() { watcher.Close() }, runState)
+			return
+
 		case ev := <-watcher.Event:
 			if ev.Name != "" {
 				base := filepath.Base(ev.Name)
 
 				if flag_includedFiles.Matches(base) || matchesPattern(pattern, ev.Name) {
 					if !flag_excludedFiles.Matches(base) {
-						jobs <- ev.Name
+						select {
+						case raw <- ev.Name:
+						default:
+							log.Println(failColor("Change queue full, dropping event for %s", ev.Name))
+						}
 					}
 				}
 			}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// stringList collects repeated occurrences of a flag, such as
+// -before-build="go generate ./..." -before-build="go vet ./...".
+type stringList []string
+
+func (s *stringList) String() string {
+	return fmt.Sprint(*s)
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// Hook is a single script run around the build step, e.g. to run
+// `go generate` before building or fire a desktop notification after a
+// failed build.
+type Hook struct {
+	// Type selects how Command is executed: "shell" runs it through
+	// the platform shell (/bin/sh -c, or cmd /c on Windows), "exec" runs
+	// it directly via the tokenized argument list. Defaults to "shell".
+	Type string `yaml:"type"`
+	// Path is the working directory the hook runs in. Defaults to ".".
+	Path string `yaml:"path"`
+	// Command is the script or executable (plus arguments) to run.
+	Command string `yaml:"command"`
+	// Output controls whether a successful hook's combined
+	// stdout/stderr is logged through the daemon's colorized logger. The
+	// output is logged once the hook has exited, not line by line while
+	// it runs, and a failing hook's output is logged whatever Output says.
+	Output bool `yaml:"output"`
+}
+
+// runHook executes a single Hook and returns its error, if any. env is
+// appended to the hook's environment, e.g. COMPILEDAEMON_CHANGED. When
+// Output is set, or the hook fails, the hook's combined output is logged
+// through the same okColor/failColor logger used for the build command.
+func runHook(h Hook, env []string) error {
+	if h.Command == "" {
+		return nil
+	}
+
+	var cmd *exec.Cmd
+
+	switch h.Type {
+	case "exec":
+		args, err := splitCommand(h.Command)
+		if err != nil {
+			return fmt.Errorf("can't parse hook command %q: %s", h.Command, err)
+		}
+		if len(args) == 0 {
+			return nil
+		}
+		cmd = exec.Command(args[0], args[1:]...)
+	default: // "shell", ""
+		cmd = shellCommand(h.Command)
+	}
+
+	if h.Path != "" {
+		cmd.Dir = h.Path
+	} else {
+		cmd.Dir = "."
+	}
+	cmd.Env = append(os.Environ(), env...)
+
+	output, err := cmd.CombinedOutput()
+
+	if h.Output || err != nil {
+		if err != nil {
+			log.Println(failColor("Hook %q failed:\n", h.Command), failColor(string(output)))
+		} else {
+			log.Println(okColor("Hook %q ok.\n", h.Command), okColor(string(output)))
+		}
+	}
+
+	return err
+}
+
+// runHooks runs each Hook in hooks in order, returning the first error
+// encountered. Later hooks are skipped once one fails.
+func runHooks(hooks []Hook, env []string) error {
+	for _, h := range hooks {
+		if err := runHook(h, env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hooks groups the three points in the build lifecycle a Hook can be
+// attached to.
+type Hooks struct {
+	BeforeBuild []Hook `yaml:"before_build"`
+	AfterBuild  []Hook `yaml:"after_build"`
+	OnFailure   []Hook `yaml:"on_failure"`
+}
+
+// cliHooks turns a list of raw command strings (as supplied via
+// repeated -before-build/-after-build/-on-failure flags) into Hooks
+// that run as shell commands with Output enabled.
+func cliHooks(commands []string) []Hook {
+	hooks := make([]Hook, len(commands))
+	for i, c := range commands {
+		hooks[i] = Hook{Type: "shell", Path: ".", Command: c, Output: true}
+	}
+	return hooks
+}
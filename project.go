@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/howeyc/fsnotify"
+)
+
+// extsToPattern turns a list of file extensions ("go", "tmpl") into the
+// same kind of regexp -pattern uses for a single-project run.
+func extsToPattern(exts []string) *regexp.Regexp {
+	if len(exts) == 0 {
+		return regexp.MustCompile(FilePattern)
+	}
+
+	quoted := make([]string, len(exts))
+	for i, ext := range exts {
+		quoted[i] = regexp.QuoteMeta(ext)
+	}
+
+	return regexp.MustCompile(`\.(` + strings.Join(quoted, "|") + `)$`)
+}
+
+// runProjects watches and builds every project in projects concurrently,
+// one builder/runner/watcher triple per project, each registered on wg
+// so shutdown() can wait for all of them to tear down. ctx cancellation
+// tears all of them down. It returns one processState per project, for
+// shutdown() to kill and read the exit status of.
+func runProjects(ctx context.Context, projects []Project, wg *sync.WaitGroup) []*processState {
+	states := make([]*processState, len(projects))
+
+	for i, proj := range projects {
+		state := &processState{}
+		states[i] = state
+
+		wg.Add(1)
+		go func(proj Project, state *processState) {
+			defer wg.Done()
+			runProject(ctx, proj, wg, state)
+		}(proj, state)
+	}
+
+	return states
+}
+
+// projectGeneration tracks the currently running set of projects so a
+// SIGHUP reload can cancel it and start a fresh one, and so the final
+// shutdown() can reach whichever generation was running at the time.
+type projectGeneration struct {
+	sync.Mutex
+	cancel context.CancelFunc
+	states []*processState
+}
+
+// start cancels the previous generation, if any, and launches projects
+// as a new one scoped to its own child context of parent.
+func (g *projectGeneration) start(parent context.Context, projects []Project, wg *sync.WaitGroup) {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.cancel != nil {
+		g.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	g.cancel = cancel
+	g.states = runProjects(ctx, projects, wg)
+}
+
+// stop cancels the current generation, if any.
+func (g *projectGeneration) stop() {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.cancel != nil {
+		g.cancel()
+	}
+}
+
+// snapshot returns the processStates of the generation running right
+// now, for shutdown() to kill and read exit statuses from.
+func (g *projectGeneration) snapshot() []*processState {
+	g.Lock()
+	defer g.Unlock()
+	return g.states
+}
+
+// runProject sets up its own fsnotify.Watcher plus builder/runner
+// goroutines scoped to a single Project, mirroring the single-project
+// flow in main() but driven by a Project's Watcher and Tools instead of
+// the global flags. Its goroutines are registered on wg, and state
+// tracks its own run command's in-flight process - both scoped to this
+// project alone, so one project's state can never affect another's.
+func runProject(ctx context.Context, proj Project, wg *sync.WaitGroup, state *processState) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("[%s] %s, not watching this project", proj.Name, err)
+		return
+	}
+
+	ignore := globList(proj.Watcher.Ignore)
+
+	watchPaths := proj.Watcher.Paths
+	if len(watchPaths) == 0 {
+		watchPaths = []string{"."}
+	}
+
+	for _, root := range watchPaths {
+		root = filepath.Join(proj.Path, root)
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				if ignore.Matches(info.Name()) {
+					return filepath.SkipDir
+				}
+				return watcher.Watch(path)
+			}
+			return err
+		})
+
+		if err != nil {
+			log.Printf("[%s] filepath.Walk(): %s, not watching this project", proj.Name, err)
+			watcher.Close()
+			return
+		}
+	}
+
+	pattern := extsToPattern(proj.Watcher.Exts)
+	scripts := globList(proj.Watcher.Scripts)
+
+	raw := make(chan string, 4**flag_maxQueue)
+	buildReqs := make(chan BuildRequest, *flag_maxQueue)
+	buildDone := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		batcher(ctx, raw, buildReqs, *flag_debounce, *flag_maxQueue)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		builder(ctx, buildReqs, buildDone, proj.Tools.Build, proj.Hooks, proj.Path)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if proj.Tools.Run != "" {
+			runner(ctx, func() (*exec.Cmd, io.ReadCloser, io.ReadCloser, error) {
+				return startProjectCommand(proj)
+			}, buildDone, state)
+		} else {
+			flusher(ctx, buildDone)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			watcher.Close()
+			return
+
+		case ev := <-watcher.Event:
+			if ev.Name == "" {
+				continue
+			}
+
+			base := filepath.Base(ev.Name)
+
+			if matchesPattern(pattern, ev.Name) || scripts.Matches(base) {
+				if !ignore.Matches(base) {
+					select {
+					case raw <- ev.Name:
+					default:
+						log.Printf("[%s] change queue full, dropping event for %s", proj.Name, ev.Name)
+					}
+				}
+			}
+
+		case err := <-watcher.Error:
+			log.Printf("[%s] watcher.Error: %s", proj.Name, err)
+		}
+	}
+}
+
+// startProjectCommand starts a Project's run command directly via
+// exec.Command, with Args passed as a proper argument vector and Env
+// applied to cmd.Env, rather than round-tripping through a joined
+// string and the shellwords splitter.
+func startProjectCommand(proj Project) (cmd *exec.Cmd, stdout io.ReadCloser, stderr io.ReadCloser, err error) {
+	cmd = exec.Command(proj.Tools.Run, proj.Args...)
+	cmd.Dir = proj.Path
+
+	if len(proj.Env) > 0 {
+		cmd.Env = append(os.Environ(), proj.Env...)
+	}
+
+	stdout, stderr, err = startProcess(cmd)
+	return
+}
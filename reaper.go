@@ -0,0 +1,12 @@
+package main
+
+// Exit describes how a reaped child process ended, as reported by
+// reaperWait.
+type Exit struct {
+	Pid    int
+	Status int
+}
+
+func init() {
+	startReaper()
+}
@@ -0,0 +1,126 @@
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// reaper owns every child CompileDaemon has started: a single SIGCHLD
+// handler drains all zombies with syscall.Wait4(-1, ..., WNOHANG) and
+// routes each exit to whichever caller is waiting on that pid, or - if
+// nothing is waiting yet - stashes it in reaperDone so a caller that
+// shows up later (e.g. killProcessHard on the next rebuild, after the
+// child already crashed) still gets the right status instead of racing
+// a process.Wait() against the exit.
+var (
+	reaperMu         sync.Mutex
+	reaperRegistered = map[int]bool{}
+	reaperSubs       = map[int]chan Exit{}
+	reaperDone       = map[int]Exit{}
+	reaperOnce       sync.Once
+)
+
+func startReaper() {
+	reaperOnce.Do(func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGCHLD)
+
+		go func() {
+			for range sigs {
+				reapAll()
+			}
+		}()
+	})
+}
+
+// reapAll drains every zombie child currently waiting to be reaped. A
+// pid with an active reaperWait gets its exit delivered through that
+// waiter's channel; a registered pid with no waiter yet (e.g. it
+// crashed before anyone asked about it) has its exit stashed in
+// reaperDone for a later reaperWait/reaperTryWait to pick up.
+func reapAll() {
+	for {
+		var status syscall.WaitStatus
+
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err != nil || pid <= 0 {
+			return
+		}
+
+		exit := Exit{Pid: pid, Status: status.ExitStatus()}
+
+		reaperMu.Lock()
+		if ch, ok := reaperSubs[pid]; ok {
+			delete(reaperSubs, pid)
+			delete(reaperRegistered, pid)
+			reaperMu.Unlock()
+			ch <- exit
+			continue
+		}
+		if reaperRegistered[pid] {
+			delete(reaperRegistered, pid)
+			reaperDone[pid] = exit
+		}
+		reaperMu.Unlock()
+	}
+}
+
+// reaperRegister must be called right after a child is started so its
+// eventual SIGCHLD has somewhere to deliver the exit status.
+func reaperRegister(pid int) {
+	reaperMu.Lock()
+	reaperRegistered[pid] = true
+	reaperMu.Unlock()
+}
+
+// reaperTryWait reports a registered pid's exit status without
+// blocking, if the reaper has already collected it, consuming it in
+// the process. It reports ok=false if the pid hasn't exited yet (or
+// was never registered).
+func reaperTryWait(pid int) (Exit, bool) {
+	reaperMu.Lock()
+	defer reaperMu.Unlock()
+
+	exit, ok := reaperDone[pid]
+	if ok {
+		delete(reaperDone, pid)
+	}
+	return exit, ok
+}
+
+// reaperWait blocks until the previously reaperRegister'd pid exits, or
+// timeout elapses.
+func reaperWait(pid int, timeout time.Duration) (Exit, error) {
+	reaperMu.Lock()
+	if exit, ok := reaperDone[pid]; ok {
+		delete(reaperDone, pid)
+		reaperMu.Unlock()
+		return exit, nil
+	}
+
+	if !reaperRegistered[pid] {
+		reaperMu.Unlock()
+		return Exit{}, fmt.Errorf("reaper: pid %d was never registered", pid)
+	}
+
+	ch := make(chan Exit, 1)
+	reaperSubs[pid] = ch
+	reaperMu.Unlock()
+
+	select {
+	case e := <-ch:
+		return e, nil
+	case <-time.After(timeout):
+		reaperMu.Lock()
+		delete(reaperSubs, pid)
+		delete(reaperRegistered, pid)
+		reaperMu.Unlock()
+		return Exit{}, fmt.Errorf("reaper: timed out waiting for pid %d to exit", pid)
+	}
+}
@@ -0,0 +1,33 @@
+// +build windows
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// Windows has no SIGCHLD/wait4 to centralize, so reaperWait falls back
+// to looking the process up by pid and waiting on it directly.
+
+func startReaper() {}
+
+func reaperRegister(pid int) {}
+
+// reaperTryWait has no centralized reap to consult on Windows, so it
+// always reports that the pid's exit isn't known yet.
+func reaperTryWait(pid int) (Exit, bool) { return Exit{}, false }
+
+func reaperWait(pid int, timeout time.Duration) (Exit, error) {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return Exit{}, err
+	}
+
+	state, err := process.Wait()
+	if err != nil {
+		return Exit{}, err
+	}
+
+	return Exit{Pid: pid, Status: state.ExitCode()}, nil
+}
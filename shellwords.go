@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// splitCommand tokenizes a command string the way a shell would: words
+// are separated by whitespace, single and double quotes group words
+// containing spaces, and a backslash escapes the next character.
+// Environment variables (`$FOO`, `${FOO}`) are expanded first via
+// os.ExpandEnv, so quoting does not protect a variable from expansion -
+// a simplification over a real shell, but enough for build/run/hook
+// command strings.
+func splitCommand(command string) ([]string, error) {
+	return splitWords(os.ExpandEnv(command))
+}
+
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var buf strings.Builder
+	inWord := false
+
+	i := 0
+	for i < len(s) {
+		c := s[i]
+
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, buf.String())
+				buf.Reset()
+				inWord = false
+			}
+			i++
+
+		case c == '\'':
+			inWord = true
+			i++
+			start := i
+			for i < len(s) && s[i] != '\'' {
+				i++
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated single quote in %q", s)
+			}
+			buf.WriteString(s[start:i])
+			i++
+
+		case c == '"':
+			inWord = true
+			i++
+			for i < len(s) && s[i] != '"' {
+				if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+					buf.WriteByte(s[i+1])
+					i += 2
+					continue
+				}
+				buf.WriteByte(s[i])
+				i++
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated double quote in %q", s)
+			}
+			i++
+
+		case c == '\\' && i+1 < len(s):
+			inWord = true
+			buf.WriteByte(s[i+1])
+			i += 2
+
+		default:
+			inWord = true
+			buf.WriteByte(c)
+			i++
+		}
+	}
+
+	if inWord {
+		words = append(words, buf.String())
+	}
+
+	return words, nil
+}
+
+// shellCommand builds a command that runs the given string through the
+// platform shell, for -shell passthrough mode.
+func shellCommand(command string) *exec.Cmd {
+	return shellCommandForGOOS(runtime.GOOS, command)
+}
+
+func shellCommandForGOOS(goos, command string) *exec.Cmd {
+	if goos == "windows" {
+		return exec.Command("cmd", "/c", command)
+	}
+	return exec.Command("/bin/sh", "-c", command)
+}
+
+// makeCommand turns a raw command string into an *exec.Cmd, either by
+// tokenizing it with splitCommand or, with -shell set, by handing the
+// whole string to the platform shell.
+func makeCommand(command string) (*exec.Cmd, error) {
+	if *flag_shell {
+		return shellCommand(command), nil
+	}
+
+	args, err := splitCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	return exec.Command(args[0], args[1:]...), nil
+}
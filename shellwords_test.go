@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommandSimple(t *testing.T) {
+	got, err := splitCommand("go build -v ./...")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{"go", "build", "-v", "./..."}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitCommand() = %#v, want %#v", got, want)
+	}
+}
+
+func TestSplitCommandQuotedSpaces(t *testing.T) {
+	got, err := splitCommand(`./srv -flag="a b" 'another arg'`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{"./srv", "-flag=a b", "another arg"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitCommand() = %#v, want %#v", got, want)
+	}
+}
+
+func TestSplitCommandNestedQuotes(t *testing.T) {
+	got, err := splitCommand(`echo "it's fine" 'she said "hi"'`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{"echo", "it's fine", `she said "hi"`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitCommand() = %#v, want %#v", got, want)
+	}
+}
+
+func TestSplitCommandEscapedCharacters(t *testing.T) {
+	got, err := splitCommand(`echo foo\ bar "escaped \" quote"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{"echo", "foo bar", `escaped " quote`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitCommand() = %#v, want %#v", got, want)
+	}
+}
+
+func TestSplitCommandEnvExpansion(t *testing.T) {
+	os.Setenv("COMPILEDAEMON_TEST_VAR", "world")
+	defer os.Unsetenv("COMPILEDAEMON_TEST_VAR")
+
+	got, err := splitCommand(`echo hello $COMPILEDAEMON_TEST_VAR "and ${COMPILEDAEMON_TEST_VAR}"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{"echo", "hello", "world", "and world"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitCommand() = %#v, want %#v", got, want)
+	}
+}
+
+func TestSplitCommandUnterminatedQuote(t *testing.T) {
+	if _, err := splitCommand(`echo "unterminated`); err == nil {
+		t.Fatal("expected an error for an unterminated quote, got nil")
+	}
+}
+
+func TestShellCommandPerPlatform(t *testing.T) {
+	cmd := shellCommandForGOOS("windows", `echo hi`)
+	if cmd.Args[0] != "cmd" || cmd.Args[1] != "/c" {
+		t.Errorf("windows shellCommand = %#v, want cmd /c ...", cmd.Args)
+	}
+
+	cmd = shellCommandForGOOS("linux", `echo hi`)
+	if cmd.Args[0] != "/bin/sh" || cmd.Args[1] != "-c" {
+		t.Errorf("unix shellCommand = %#v, want /bin/sh -c ...", cmd.Args)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// HammerTime is how long shutdown() waits for goroutines to finish
+// tearing down cooperatively before giving up and exiting anyway.
+const HammerTime = 5 * time.Second
+
+// processState is the process a single runner() goroutine currently has
+// in flight, if any. shutdown() needs to reach it from outside
+// runner()'s own loop when a termination signal arrives mid-build. It
+// is scoped per runner (one per project in -config mode) rather than
+// shared, so one project's run-command state can never clobber
+// another's.
+type processState struct {
+	sync.Mutex
+	proc       *os.Process
+	lastStatus int
+}
+
+func (s *processState) setRunningProcess(p *os.Process) {
+	s.Lock()
+	s.proc = p
+	s.Unlock()
+}
+
+func (s *processState) killRunningProcess() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.proc != nil {
+		s.lastStatus = killProcess(s.proc)
+		s.proc = nil
+	}
+}
+
+// lastExitStatus returns the exit status of the last process killed by
+// killRunningProcess: 0 if none has been killed yet, or -1 if its
+// status couldn't be determined.
+func (s *processState) lastExitStatus() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.lastStatus
+}
+
+// installSignalHandler watches for SIGINT/SIGTERM/SIGHUP and drives
+// cooperative shutdown through cancel, or reload on SIGHUP. SIGCHLD is
+// handled separately by the reaper (see reaper_unix.go) and must not be
+// touched here.
+func installSignalHandler(cancel context.CancelFunc, reload func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	go func() {
+		for sig := range sigs {
+			switch sig {
+			case syscall.SIGHUP:
+				log.Println(okColor("Caught SIGHUP, reloading."))
+				reload()
+			default:
+				log.Println(okColor("Caught %s, shutting down.", sig))
+				cancel()
+				return
+			}
+		}
+	}()
+}
+
+// shutdown waits for the builder/runner goroutines tracked by wg to
+// return after ctx has been canceled, kills any process still running
+// in states, closes the watcher and force-exits if teardown takes
+// longer than HammerTime. On a clean teardown it propagates the first
+// positive exit status among states as CompileDaemon's own, so a
+// wrapping process sees the same result the watched command(s) would
+// have produced on their own.
+func shutdown(wg *sync.WaitGroup, closeWatcher func(), states ...*processState) {
+	for _, s := range states {
+		s.killRunningProcess()
+	}
+	closeWatcher()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		os.Exit(exitStatus(states))
+	case <-time.After(HammerTime):
+		log.Println(failColor("Shutdown took too long, forcing exit."))
+		os.Exit(1)
+	}
+}
+
+// exitStatus returns the first positive exit status among states, or 0
+// if every state exited cleanly (or never ran a command at all). A
+// status of -1 (undetermined) is skipped.
+func exitStatus(states []*processState) int {
+	for _, s := range states {
+		if status := s.lastExitStatus(); status > 0 {
+			return status
+		}
+	}
+	return 0
+}